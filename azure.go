@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -10,6 +9,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/RobustPerception/azure_metrics_exporter/config"
@@ -39,6 +39,18 @@ type metricDefinitionResponse struct {
 	Unit                   string `json:"unit"`
 }
 
+// AzureMetricNamespaceResponse represents the metric namespaces available for
+// a given resource, used to discover non-default namespaces such as guest OS,
+// custom, or Log Analytics-published metrics.
+type AzureMetricNamespaceResponse struct {
+	MetricNamespaceResponses []metricNamespaceResponse `json:"value"`
+}
+type metricNamespaceResponse struct {
+	Properties struct {
+		MetricNamespaceName string `json:"metricNamespaceName"`
+	} `json:"properties"`
+}
+
 // AzureMetricValueResponse represents a metric value response for a given metric definition.
 type AzureMetricValueResponse struct {
 	Value []struct {
@@ -65,81 +77,269 @@ type AzureMetricValueResponse struct {
 	} `json:"error"`
 }
 
-// AzureBatchResponse contains the result of several get metrics requests
-type AzureBatchResponse struct {
-	Responses []struct {
-		HttpStatusCode int                      `json:"httpStatusCode"`
-		Headers        map[string]string        `json:"headers"`
-		Content        AzureMetricValueResponse `json:"content"`
-		ContentLength  int                      `json:"contentLength"`
-	} `json:"responses"`
+// AzureMetricBaselineResponse represents Azure Monitor's computed sensitivity
+// bands and predicted values for a given metric.
+type AzureMetricBaselineResponse struct {
+	Baseline []struct {
+		Sensitivity    string    `json:"sensitivity"`
+		LowThresholds  []float64 `json:"lowThresholds"`
+		HighThresholds []float64 `json:"highThresholds"`
+		Timestamps     []string  `json:"timestamps"`
+	} `json:"baseline"`
+	PredictedResult struct {
+		Data []struct {
+			TimeStamp string  `json:"timeStamp"`
+			Average   float64 `json:"average"`
+		} `json:"data"`
+	} `json:"predictedResult"`
+	Timespan string `json:"timespan"`
+	Interval string `json:"interval"`
 }
 
-// AzureClient represents our client to talk to the Azure api
-type AzureClient struct {
-	client               *http.Client
+// credsKey identifies a distinct set of Azure credentials (tenant+client, or
+// a specific managed identity) so tokens for different subscriptions/tenants
+// don't clobber each other.
+type credsKey struct {
+	tenantID    string
+	clientID    string
+	useMSI        bool
+	msiClientID   string
+	msiResourceID string
+}
+
+// tokenState holds a cached bearer token for one credsKey.
+type tokenState struct {
 	accessToken          string
 	accessTokenExpiresOn time.Time
 }
 
+// AzureClient represents our client to talk to the Azure api. It maintains a
+// token per distinct set of credentials so a single exporter instance can
+// scrape targets across multiple subscriptions and tenants.
+type AzureClient struct {
+	client *http.Client
+	mu     sync.Mutex
+	tokens map[credsKey]*tokenState
+}
+
 // NewAzureClient returns an Azure client to talk the Azure API
 func NewAzureClient() *AzureClient {
 	return &AzureClient{
-		client:               &http.Client{},
-		accessToken:          "",
-		accessTokenExpiresOn: time.Time{},
+		client: &http.Client{},
+		tokens: make(map[credsKey]*tokenState),
+	}
+}
+
+func keyForCredentials(creds config.Credentials) credsKey {
+	if creds.UseManagedIdentity {
+		return credsKey{useMSI: true, msiClientID: creds.IdentityClientID, msiResourceID: creds.IdentityResourceID}
+	}
+	return credsKey{tenantID: creds.TenantID, clientID: creds.ClientID}
+}
+
+// credentialsForTarget resolves which set of credentials a target should use:
+// its own credentials_ref if set, falling back to the default credentials.
+func credentialsForTarget(target config.Target) config.Credentials {
+	if target.CredentialsRef != "" {
+		if creds, ok := sc.C.CredentialsRefs[target.CredentialsRef]; ok {
+			return creds
+		}
 	}
+	return sc.C.Credentials
 }
 
-func (ac *AzureClient) getAccessToken() error {
-	target := fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/token", sc.C.Credentials.TenantID)
+// subscriptionForTarget resolves which subscription a target's metrics live
+// in: its own subscription_id if set, falling back to the resolved
+// credentials' subscription.
+func subscriptionForTarget(target config.Target, creds config.Credentials) string {
+	if target.SubscriptionID != "" {
+		return target.SubscriptionID
+	}
+	return creds.SubscriptionID
+}
+
+const msiEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
+// getToken returns a valid bearer token for creds, fetching or refreshing it
+// if the cached one is missing or within 10 minutes of expiring.
+func (ac *AzureClient) getToken(creds config.Credentials) (string, error) {
+	key := keyForCredentials(creds)
+
+	ac.mu.Lock()
+	state := ac.tokens[key]
+	ac.mu.Unlock()
+
+	now := time.Now().UTC()
+	if state != nil && now.Before(state.accessTokenExpiresOn.Add(-10*time.Minute)) {
+		return state.accessToken, nil
+	}
+
+	token, expiresOn, err := ac.fetchAccessToken(creds)
+	if err != nil {
+		return "", fmt.Errorf("Error refreshing access token: %v", err)
+	}
+
+	ac.mu.Lock()
+	ac.tokens[key] = &tokenState{accessToken: token, accessTokenExpiresOn: expiresOn}
+	ac.mu.Unlock()
+
+	return token, nil
+}
+
+func (ac *AzureClient) fetchAccessToken(creds config.Credentials) (string, time.Time, error) {
+	if creds.UseManagedIdentity {
+		return ac.fetchAccessTokenMSI(creds)
+	}
+
+	target := fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/token", creds.TenantID)
 	form := url.Values{
 		"grant_type":    {"client_credentials"},
 		"resource":      {"https://management.azure.com/"},
-		"client_id":     {sc.C.Credentials.ClientID},
-		"client_secret": {sc.C.Credentials.ClientSecret},
+		"client_id":     {creds.ClientID},
+		"client_secret": {creds.ClientSecret},
 	}
 	resp, err := ac.client.PostForm(target, form)
 	if err != nil {
-		return fmt.Errorf("Error authenticating against Azure API: %v", err)
+		return "", time.Time{}, fmt.Errorf("Error authenticating against Azure API: %v", err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("Did not get status code 200, got: %d", resp.StatusCode)
+		return "", time.Time{}, fmt.Errorf("Did not get status code 200, got: %d", resp.StatusCode)
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("Error reading body of response: %v", err)
+		return "", time.Time{}, fmt.Errorf("Error reading body of response: %v", err)
 	}
 	var data map[string]interface{}
 	err = json.Unmarshal(body, &data)
 	if err != nil {
-		return fmt.Errorf("Error unmarshalling response body: %v", err)
+		return "", time.Time{}, fmt.Errorf("Error unmarshalling response body: %v", err)
 	}
-	ac.accessToken = data["access_token"].(string)
+	accessToken := data["access_token"].(string)
 	expiresOn, err := strconv.ParseInt(data["expires_on"].(string), 10, 64)
 	if err != nil {
-		return fmt.Errorf("Error ParseInt of expires_on failed: %v", err)
+		return "", time.Time{}, fmt.Errorf("Error ParseInt of expires_on failed: %v", err)
+	}
+
+	return accessToken, time.Unix(expiresOn, 0).UTC(), nil
+}
+
+// fetchAccessTokenMSI obtains a bearer token from the Instance Metadata
+// Service instead of performing a client-credentials exchange. This lets the
+// exporter run on an Azure VM or in AKS with Pod Identity without any static
+// secrets in its config.
+func (ac *AzureClient) fetchAccessTokenMSI(creds config.Credentials) (string, time.Time, error) {
+	values := url.Values{
+		"api-version": {"2018-02-01"},
+		"resource":    {"https://management.azure.com/"},
+	}
+	if creds.IdentityClientID != "" {
+		values.Add("client_id", creds.IdentityClientID)
+	}
+	if creds.IdentityResourceID != "" {
+		values.Add("msi_res_id", creds.IdentityResourceID)
+	}
+
+	req, err := http.NewRequest("GET", msiEndpoint+"?"+values.Encode(), nil)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("Error creating MSI token request: %v", err)
+	}
+	req.Header.Set("Metadata", "true")
+
+	resp, err := ac.client.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("Error authenticating against MSI endpoint: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", time.Time{}, fmt.Errorf("Did not get status code 200 from MSI endpoint, got: %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("Error reading body of MSI response: %v", err)
+	}
+	var data struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"`
+		ExpiresIn   string `json:"expires_in"`
+		NotBefore   string `json:"not_before"`
+	}
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("Error unmarshalling MSI response body: %v", err)
+	}
+
+	if data.ExpiresOn != "" {
+		expiresOn, err := strconv.ParseInt(data.ExpiresOn, 10, 64)
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("Error ParseInt of MSI expires_on failed: %v", err)
+		}
+		return data.AccessToken, time.Unix(expiresOn, 0).UTC(), nil
+	} else if data.ExpiresIn != "" {
+		expiresIn, err := strconv.ParseInt(data.ExpiresIn, 10, 64)
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("Error ParseInt of MSI expires_in failed: %v", err)
+		}
+		return data.AccessToken, time.Now().UTC().Add(time.Duration(expiresIn) * time.Second), nil
 	}
-	ac.accessTokenExpiresOn = time.Unix(expiresOn, 0).UTC()
 
-	return nil
+	return data.AccessToken, time.Time{}, nil
 }
 
 // Loop through all specified resource targets and get their respective metric definitions.
 func (ac *AzureClient) getMetricDefinitions() (map[string]AzureMetricDefinitionResponse, error) {
-	apiVersion := "2018-01-01"
 	definitions := make(map[string]AzureMetricDefinitionResponse)
 
 	for _, target := range sc.C.Targets {
-		metricsResource := fmt.Sprintf("subscriptions/%s%s", sc.C.Credentials.SubscriptionID, target.Resource)
+		namespacedDefs, err := ac.getMetricDefinitionsForTarget(target)
+		if err != nil {
+			return nil, err
+		}
+		for _, nd := range namespacedDefs {
+			definitions[definitionKey(target.Resource, nd.namespace)] = nd.def
+		}
+	}
+	return definitions, nil
+}
+
+// namespacedDefinition pairs a metric definition response with the namespace
+// it was queried from, so callers that need to iterate per-namespace (the
+// collector, the pre-aggregation cache) don't have to re-derive it from a
+// definitionKey.
+type namespacedDefinition struct {
+	namespace string
+	def       AzureMetricDefinitionResponse
+}
+
+// getMetricDefinitionsForTarget fetches metric definitions for a single
+// target, across every namespace it resolves to.
+func (ac *AzureClient) getMetricDefinitionsForTarget(target config.Target) ([]namespacedDefinition, error) {
+	const apiVersion = "2018-01-01"
+	creds := credentialsForTarget(target)
+	token, err := ac.getToken(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces, err := ac.resolveMetricNamespaces(target, creds, token)
+	if err != nil {
+		return nil, err
+	}
+
+	defs := make([]namespacedDefinition, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		metricsResource := fmt.Sprintf("subscriptions/%s%s", subscriptionForTarget(target, creds), target.Resource)
 		metricsTarget := fmt.Sprintf("https://management.azure.com/%s/providers/microsoft.insights/metricDefinitions?api-version=%s", metricsResource, apiVersion)
+		if namespace != "" {
+			metricsTarget += "&metricnamespace=" + url.QueryEscape(namespace)
+		}
 		req, err := http.NewRequest("GET", metricsTarget, nil)
 		if err != nil {
 			return nil, fmt.Errorf("Error creating HTTP request: %v", err)
 		}
-		req.Header.Set("Authorization", "Bearer "+ac.accessToken)
+		req.Header.Set("Authorization", "Bearer "+token)
 		resp, err := ac.client.Do(req)
 		if err != nil {
 			return nil, fmt.Errorf("Error: %v", err)
@@ -158,82 +358,106 @@ func (ac *AzureClient) getMetricDefinitions() (map[string]AzureMetricDefinitionR
 		if err != nil {
 			return nil, fmt.Errorf("Error unmarshalling response body: %v", err)
 		}
-		definitions[target.Resource] = def
+		defs = append(defs, namespacedDefinition{namespace: namespace, def: def})
 	}
-	return definitions, nil
+	return defs, nil
 }
 
-type batchRequest struct {
-	Requests []batchURL `json:"requests"`
+// metricNamesFor joins the metric names in def into the comma-separated list
+// the metrics API's metricnames query parameter expects.
+func metricNamesFor(def AzureMetricDefinitionResponse) string {
+	names := make([]string, 0, len(def.MetricDefinitionResponses))
+	for _, d := range def.MetricDefinitionResponses {
+		names = append(names, d.Name.Value)
+	}
+	return strings.Join(names, ",")
 }
-type batchURL struct {
-	RelativeURL string `json:"relativeUrl"`
-	Method      string `json:"httpMethod"`
+
+// definitionKey identifies a metric definition set by resource and, when
+// present, the non-default namespace it was queried from, so identically
+// named metrics from different namespaces don't collide.
+func definitionKey(resource, namespace string) string {
+	if namespace == "" {
+		return resource
+	}
+	return resource + "|" + namespace
 }
 
-func (ac *AzureClient) doBatchRequest(urls []string) (*AzureBatchResponse, error) {
-	const batchUrl = "https://management.azure.com/batch?api-version=2017-03-01" // "http://localhost:8080/batch?api-version=2017-03-01"
-	now := time.Now().UTC()
-	refreshAt := ac.accessTokenExpiresOn.Add(-10 * time.Minute)
-	if now.After(refreshAt) {
-		err := ac.getAccessToken()
+// resolveMetricNamespaces returns the namespaces a target's metrics should be
+// queried from: an explicit list, a single explicit namespace, an
+// auto-discovered list (when metric_namespaces is "*"), or just the default
+// platform namespace when none of the above is configured.
+func (ac *AzureClient) resolveMetricNamespaces(target config.Target, creds config.Credentials, token string) ([]string, error) {
+	switch {
+	case len(target.MetricNamespaces) == 1 && target.MetricNamespaces[0] == "*":
+		namespaces, err := ac.getMetricNamespaces(target, creds, token)
 		if err != nil {
-			return nil, fmt.Errorf("Error refreshing access token: %v", err)
+			return nil, err
 		}
+		// A resource with no extra namespaces still exposes the default
+		// platform metrics; without this, auto-discovery finding nothing
+		// would silently drop the target to zero metrics.
+		return append(namespaces, ""), nil
+	case len(target.MetricNamespaces) > 0:
+		return target.MetricNamespaces, nil
+	case target.MetricNamespace != "":
+		return []string{target.MetricNamespace}, nil
+	default:
+		return []string{""}, nil
 	}
+}
 
-	batch := batchRequest{make([]batchURL, len(urls))}
-	for idx, url := range urls {
-		batch.Requests[idx] = batchURL{url, "GET"}
-	}
-	var reqBody bytes.Buffer
-	enc := json.NewEncoder(&reqBody)
-	enc.SetEscapeHTML(false) // Azure does not handle the &:s becoming \u0026 in the urls
-	err := enc.Encode(batch)
-	if err != nil {
-		return nil, err
-	}
+// getMetricNamespaces discovers the metric namespaces available for a
+// resource, e.g. azure.applicationinsights or Azure.VM.Windows.GuestMetrics.
+func (ac *AzureClient) getMetricNamespaces(target config.Target, creds config.Credentials, token string) ([]string, error) {
+	const apiVersion = "2017-12-01-preview"
+	metricsResource := fmt.Sprintf("subscriptions/%s%s", subscriptionForTarget(target, creds), target.Resource)
+	namespacesTarget := fmt.Sprintf("https://management.azure.com/%s/providers/microsoft.insights/metricNamespaces?api-version=%s", metricsResource, apiVersion)
 
-	req, err := http.NewRequest("POST", batchUrl, &reqBody)
+	req, err := http.NewRequest("GET", namespacesTarget, nil)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("Error creating HTTP request: %v", err)
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+ac.accessToken)
-
+	req.Header.Set("Authorization", "Bearer "+token)
 	resp, err := ac.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("Error: %v", err)
 	}
-
 	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("Unable to query metrics API with status code: %d", resp.StatusCode)
-	}
-
-	respBody, err := ioutil.ReadAll(resp.Body)
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("Error reading body of response: %v", err)
 	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error: %v", string(body))
+	}
 
-	var data AzureBatchResponse
-	err = json.Unmarshal(respBody, &data)
+	var data AzureMetricNamespaceResponse
+	err = json.Unmarshal(body, &data)
 	if err != nil {
 		return nil, fmt.Errorf("Error unmarshalling response body: %v", err)
 	}
 
-	return &data, nil
+	namespaces := make([]string, 0, len(data.MetricNamespaceResponses))
+	for _, ns := range data.MetricNamespaceResponses {
+		namespaces = append(namespaces, ns.Properties.MetricNamespaceName)
+	}
+	return namespaces, nil
 }
 
-func (ac *AzureClient) getMetricURL(metricNames string, target config.Target) string {
+func (ac *AzureClient) getMetricURL(metricNames string, namespace string, target config.Target) string {
 	const apiVersion = "2018-01-01"
-	metricValueEndpoint := fmt.Sprintf("/subscriptions/%s%s/providers/microsoft.insights/metrics", sc.C.Credentials.SubscriptionID, target.Resource)
+	subscriptionID := subscriptionForTarget(target, credentialsForTarget(target))
+	metricValueEndpoint := fmt.Sprintf("/subscriptions/%s%s/providers/microsoft.insights/metrics", subscriptionID, target.Resource)
 	endTime, startTime := GetTimes()
 
 	values := url.Values{}
 	if metricNames != "" {
 		values.Add("metricnames", metricNames)
 	}
+	if namespace != "" {
+		values.Add("metricnamespace", namespace)
+	}
 	if len(target.Aggregations) > 0 {
 		values.Add("aggregation", strings.Join(target.Aggregations, ","))
 	} else {
@@ -250,61 +474,86 @@ func (ac *AzureClient) getMetricURL(metricNames string, target config.Target) st
 	return url.String()
 }
 
-func (ac *AzureClient) getMetricValue(metricNames string, target config.Target) (AzureMetricValueResponse, error) {
-	apiVersion := "2018-01-01"
-	now := time.Now().UTC()
-	refreshAt := ac.accessTokenExpiresOn.Add(-10 * time.Minute)
-	if now.After(refreshAt) {
-		err := ac.getAccessToken()
-		if err != nil {
-			return AzureMetricValueResponse{}, fmt.Errorf("Error refreshing access token: %v", err)
-		}
+// getMetricValue fetches one target/metricNames/namespace combination's
+// metric values through the $batch endpoint (via doBatchRequest), so every
+// fetch benefits from the same chunking, retry/backoff, 429 handling and
+// ratelimit tracking as a multi-item batch, even though this call only ever
+// submits a single item.
+func (ac *AzureClient) getMetricValue(metricNames string, namespace string, target config.Target) (AzureMetricValueResponse, error) {
+	relativeURL := ac.getMetricURL(metricNames, namespace, target)
+
+	resp, errs := ac.doBatchRequest([]batchItem{{url: relativeURL, target: target}})
+	if err, ok := errs[relativeURL]; ok {
+		return AzureMetricValueResponse{}, err
+	}
+
+	sub := resp.Responses[0]
+	if sub.HttpStatusCode != 0 && sub.HttpStatusCode != http.StatusOK {
+		return AzureMetricValueResponse{}, fmt.Errorf("Unable to query metrics API with status code: %d", sub.HttpStatusCode)
 	}
+	return sub.Content, nil
+}
 
-	metricsResource := fmt.Sprintf("subscriptions/%s%s", sc.C.Credentials.SubscriptionID, target.Resource)
+var defaultSensitivities = []string{"Low", "Medium", "High"}
+
+func sensitivitiesForTarget(target config.Target) []string {
+	if len(target.Sensitivities) > 0 {
+		return target.Sensitivities
+	}
+	return defaultSensitivities
+}
+
+// getMetricBaseline fetches Azure Monitor's computed baseline (sensitivity
+// bands and predicted values) for a single metric, alongside getMetricValue.
+// It is only queried when a target opts in with `baselines: true`.
+func (ac *AzureClient) getMetricBaseline(metricName string, namespace string, target config.Target) (AzureMetricBaselineResponse, error) {
+	const apiVersion = "2019-03-01"
+	creds := credentialsForTarget(target)
+	token, err := ac.getToken(creds)
+	if err != nil {
+		return AzureMetricBaselineResponse{}, err
+	}
+
+	metricsResource := fmt.Sprintf("subscriptions/%s%s", subscriptionForTarget(target, creds), target.Resource)
 	endTime, startTime := GetTimes()
 
-	metricValueEndpoint := fmt.Sprintf("https://management.azure.com/%s/providers/microsoft.insights/metrics", metricsResource)
+	baselineEndpoint := fmt.Sprintf("https://management.azure.com/%s/providers/microsoft.insights/metrics/%s/baselines", metricsResource, metricName)
 
-	req, err := http.NewRequest("GET", metricValueEndpoint, nil)
+	req, err := http.NewRequest("GET", baselineEndpoint, nil)
 	if err != nil {
-		return AzureMetricValueResponse{}, fmt.Errorf("Error creating HTTP request: %v", err)
+		return AzureMetricBaselineResponse{}, fmt.Errorf("Error creating HTTP request: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+ac.accessToken)
+	req.Header.Set("Authorization", "Bearer "+token)
 
 	values := url.Values{}
-	if metricNames != "" {
-		values.Add("metricnames", metricNames)
-	}
-	if len(target.Aggregations) > 0 {
-		values.Add("aggregation", strings.Join(target.Aggregations, ","))
-	} else {
-		values.Add("aggregation", "Total,Average,Minimum,Maximum")
-	}
+	values.Add("sensitivities", strings.Join(sensitivitiesForTarget(target), ","))
 	values.Add("timespan", fmt.Sprintf("%s/%s", startTime, endTime))
+	values.Add("interval", "PT1M")
 	values.Add("api-version", apiVersion)
-
+	if namespace != "" {
+		values.Add("metricnamespace", namespace)
+	}
 	req.URL.RawQuery = values.Encode()
 
 	log.Printf("GET %s", req.URL)
 	resp, err := ac.client.Do(req)
 	if err != nil {
-		return AzureMetricValueResponse{}, fmt.Errorf("Error: %v", err)
+		return AzureMetricBaselineResponse{}, fmt.Errorf("Error: %v", err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return AzureMetricValueResponse{}, fmt.Errorf("Unable to query metrics API with status code: %d", resp.StatusCode)
+		return AzureMetricBaselineResponse{}, fmt.Errorf("Unable to query baselines API with status code: %d", resp.StatusCode)
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return AzureMetricValueResponse{}, fmt.Errorf("Error reading body of response: %v", err)
+		return AzureMetricBaselineResponse{}, fmt.Errorf("Error reading body of response: %v", err)
 	}
 
-	var data AzureMetricValueResponse
+	var data AzureMetricBaselineResponse
 	err = json.Unmarshal(body, &data)
 	if err != nil {
-		return AzureMetricValueResponse{}, fmt.Errorf("Error unmarshalling response body: %v", err)
+		return AzureMetricBaselineResponse{}, fmt.Errorf("Error unmarshalling response body: %v", err)
 	}
 
 	return data, nil
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+)
+
+// AzureBatchResponse contains the result of several get metrics requests
+type AzureBatchResponse struct {
+	Responses []azureBatchSubResponse `json:"responses"`
+}
+
+type azureBatchSubResponse struct {
+	HttpStatusCode int                      `json:"httpStatusCode"`
+	Headers        map[string]string        `json:"headers"`
+	Content        AzureMetricValueResponse `json:"content"`
+	ContentLength  int                      `json:"contentLength"`
+}
+
+type batchRequest struct {
+	Requests []batchURL `json:"requests"`
+}
+type batchURL struct {
+	RelativeURL string `json:"relativeUrl"`
+	Method      string `json:"httpMethod"`
+}
+
+// batchItem pairs a relative metrics URL with the target it was built for, so
+// doBatchRequest knows which credentials to authenticate the request with.
+type batchItem struct {
+	url    string
+	target config.Target
+}
+
+const (
+	// maxBatchSize is the number of sub-requests Azure's $batch endpoint
+	// accepts per call.
+	maxBatchSize = 20
+
+	defaultMaxConcurrency = 5
+	maxBatchRetries       = 4
+)
+
+var (
+	apiRatelimitRemaining = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "azure_api_ratelimit_remaining",
+		Help: "Remaining reads against the Azure Monitor subscription rate limit, as last reported by the API.",
+	}, []string{"subscription"})
+	apiRetriesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "azure_api_retries_total",
+		Help: "Total number of batch sub-requests retried after a failure or 429 response.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(apiRatelimitRemaining)
+	prometheus.MustRegister(apiRetriesTotal)
+}
+
+func maxConcurrency() int {
+	if sc.C.MaxConcurrency > 0 {
+		return sc.C.MaxConcurrency
+	}
+	return defaultMaxConcurrency
+}
+
+// doBatchRequest sends urls to the $batch endpoint. Items are grouped by the
+// credentials their target resolves to, split into chunks of at most
+// maxBatchSize, and fanned out over a bounded worker pool, since the batch
+// endpoint only accepts requests against a single subscription's ARM tenant
+// and caps at maxBatchSize sub-requests per call. A single bad resource
+// doesn't blank out the whole response: the merged result is returned
+// alongside a map of per-URL errors for whatever didn't succeed after
+// retries.
+func (ac *AzureClient) doBatchRequest(items []batchItem) (*AzureBatchResponse, map[string]error) {
+	type group struct {
+		creds   config.Credentials
+		indices []int
+	}
+	groups := make(map[credsKey]*group)
+	for i, item := range items {
+		creds := credentialsForTarget(item.target)
+		key := keyForCredentials(creds)
+		g, ok := groups[key]
+		if !ok {
+			g = &group{creds: creds}
+			groups[key] = g
+		}
+		g.indices = append(g.indices, i)
+	}
+
+	type chunkJob struct {
+		indices []int
+		creds   config.Credentials
+	}
+	var jobs []chunkJob
+	for _, g := range groups {
+		for i := 0; i < len(g.indices); i += maxBatchSize {
+			end := i + maxBatchSize
+			if end > len(g.indices) {
+				end = len(g.indices)
+			}
+			jobs = append(jobs, chunkJob{indices: g.indices[i:end], creds: g.creds})
+		}
+	}
+
+	merged := make([]azureBatchSubResponse, len(items))
+	errs := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrency())
+
+	for _, job := range jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(job chunkJob) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			urls := make([]string, len(job.indices))
+			subscriptions := make([]string, len(job.indices))
+			for i, idx := range job.indices {
+				urls[i] = items[idx].url
+				subscriptions[i] = subscriptionForTarget(items[idx].target, job.creds)
+			}
+			responses, urlErrs := ac.doBatchChunkWithRetry(urls, subscriptions, job.creds)
+
+			mu.Lock()
+			defer mu.Unlock()
+			for i, idx := range job.indices {
+				merged[idx] = responses[i]
+			}
+			for url, err := range urlErrs {
+				errs[url] = err
+			}
+		}(job)
+	}
+	wg.Wait()
+
+	return &AzureBatchResponse{Responses: merged}, errs
+}
+
+// doBatchChunkWithRetry issues a single batch (at most maxBatchSize urls),
+// retrying just the sub-requests that came back with a 429 or 5xx status
+// with exponential backoff and jitter, honoring any Retry-After header, up to
+// maxBatchRetries attempts. Sub-requests still failing after that are
+// reported in the returned error map; the rest are returned as-is.
+// subscriptions holds the subscription each url in urls belongs to, so the
+// ratelimit gauge reported alongside each sub-response can be labeled
+// correctly.
+func (ac *AzureClient) doBatchChunkWithRetry(urls []string, subscriptions []string, creds config.Credentials) ([]azureBatchSubResponse, map[string]error) {
+	result := make([]azureBatchSubResponse, len(urls))
+	errs := make(map[string]error)
+
+	pendingIdx := make([]int, len(urls))
+	for i := range urls {
+		pendingIdx[i] = i
+	}
+	pendingURLs := urls
+
+	for attempt := 0; attempt <= maxBatchRetries; attempt++ {
+		if len(pendingURLs) == 0 {
+			break
+		}
+
+		resp, err := ac.doBatchRequestForCredentials(pendingURLs, creds)
+		if err != nil {
+			if attempt == maxBatchRetries {
+				for _, idx := range pendingIdx {
+					errs[urls[idx]] = err
+				}
+				break
+			}
+			apiRetriesTotal.Add(float64(len(pendingURLs)))
+			time.Sleep(batchBackoff(attempt, 0))
+			continue
+		}
+
+		var nextIdx []int
+		var nextURLs []string
+		var retryAfter time.Duration
+		for i, sub := range resp.Responses {
+			origIdx := pendingIdx[i]
+			updateRatelimitGauge(sub.Headers, subscriptions[origIdx])
+
+			if sub.HttpStatusCode == http.StatusTooManyRequests || sub.HttpStatusCode >= 500 {
+				if attempt == maxBatchRetries {
+					errs[urls[origIdx]] = fmt.Errorf("status %d after %d attempts", sub.HttpStatusCode, attempt+1)
+					continue
+				}
+				if d := retryAfterDuration(sub.Headers); d > retryAfter {
+					retryAfter = d
+				}
+				nextIdx = append(nextIdx, origIdx)
+				nextURLs = append(nextURLs, urls[origIdx])
+				apiRetriesTotal.Inc()
+				continue
+			}
+
+			result[origIdx] = sub
+		}
+
+		pendingIdx = nextIdx
+		pendingURLs = nextURLs
+		if len(pendingURLs) > 0 {
+			time.Sleep(batchBackoff(attempt, retryAfter))
+		}
+	}
+
+	return result, errs
+}
+
+// batchBackoff returns how long to wait before the next retry attempt,
+// honoring an explicit Retry-After when Azure provided one and otherwise
+// backing off exponentially with jitter.
+func batchBackoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	base := time.Duration(1<<uint(attempt)) * time.Second
+	return base + time.Duration(rand.Int63n(int64(base)))
+}
+
+func retryAfterDuration(headers map[string]string) time.Duration {
+	v, ok := headers["Retry-After"]
+	if !ok {
+		return 0
+	}
+	secs, err := strconv.Atoi(v)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+func updateRatelimitGauge(headers map[string]string, subscription string) {
+	v, ok := headers["x-ms-ratelimit-remaining-subscription-reads"]
+	if !ok {
+		return
+	}
+	remaining, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return
+	}
+	apiRatelimitRemaining.WithLabelValues(subscription).Set(remaining)
+}
+
+func (ac *AzureClient) doBatchRequestForCredentials(urls []string, creds config.Credentials) (*AzureBatchResponse, error) {
+	const batchUrl = "https://management.azure.com/batch?api-version=2017-03-01" // "http://localhost:8080/batch?api-version=2017-03-01"
+	token, err := ac.getToken(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := batchRequest{make([]batchURL, len(urls))}
+	for idx, url := range urls {
+		batch.Requests[idx] = batchURL{url, "GET"}
+	}
+	var reqBody bytes.Buffer
+	enc := json.NewEncoder(&reqBody)
+	enc.SetEscapeHTML(false) // Azure does not handle the &:s becoming \u0026 in the urls
+	err = enc.Encode(batch)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", batchUrl, &reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := ac.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Error: %v", err)
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Unable to query metrics API with status code: %d", resp.StatusCode)
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading body of response: %v", err)
+	}
+
+	var data AzureBatchResponse
+	err = json.Unmarshal(respBody, &data)
+	if err != nil {
+		return nil, fmt.Errorf("Error unmarshalling response body: %v", err)
+	}
+
+	return &data, nil
+}
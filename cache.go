@@ -0,0 +1,218 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+)
+
+var (
+	apiCallsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "azure_api_calls_total",
+		Help: "Total number of calls made to the Azure Monitor API to refresh cached metric values and baselines.",
+	})
+	cacheHitsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "azure_cache_hits_total",
+		Help: "Total number of metric scrapes served from the pre-aggregation cache without an Azure API call.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(apiCallsTotal)
+	prometheus.MustRegister(cacheHitsTotal)
+}
+
+const defaultQueryInterval = 60 * time.Second
+
+// queryIntervalForTarget returns how often target's metrics should actually
+// be fetched from Azure, defaulting to defaultQueryInterval.
+func queryIntervalForTarget(target config.Target) time.Duration {
+	if target.QueryInterval > 0 {
+		return time.Duration(target.QueryInterval) * time.Second
+	}
+	return defaultQueryInterval
+}
+
+// cacheKey identifies one cached time series by the dimensions a scrape can
+// vary on. It includes the credentials and subscription a target resolves
+// to, not just its resource path, since #chunk0-2 lets two targets share the
+// same resource string under different subscriptions/tenants.
+type cacheKey struct {
+	creds        credsKey
+	subscription string
+	resource     string
+	namespace    string
+	metricNames  string
+	aggregation  string
+}
+
+func cacheKeyFor(metricNames, namespace string, target config.Target) cacheKey {
+	creds := credentialsForTarget(target)
+	return cacheKey{
+		creds:        keyForCredentials(creds),
+		subscription: subscriptionForTarget(target, creds),
+		resource:     target.Resource,
+		namespace:    namespace,
+		metricNames:  metricNames,
+		aggregation:  strings.Join(target.Aggregations, ","),
+	}
+}
+
+type cacheEntry struct {
+	mu        sync.Mutex
+	value     AzureMetricValueResponse
+	err       error
+	fetchedAt time.Time
+}
+
+// baselineEntry is a cacheEntry for a computed metric baseline rather than a
+// metric value, keyed the same way (cacheKeyFor treats a single metric name
+// the same as a comma-joined metricNames list).
+type baselineEntry struct {
+	mu        sync.Mutex
+	value     AzureMetricBaselineResponse
+	err       error
+	fetchedAt time.Time
+}
+
+// metricCache decouples the Prometheus scrape interval from the rate at
+// which Azure Monitor is actually queried. Entries are refreshed on their
+// target's query_interval rather than on every scrape, modeled on Telegraf's
+// AggregatingOutput pattern, to amortize Azure Monitor's per-call cost and
+// 12,000 reads/hour/subscription rate limit across many scrapes.
+type metricCache struct {
+	client *AzureClient
+
+	mu        sync.Mutex
+	entries   map[cacheKey]*cacheEntry
+	baselines map[cacheKey]*baselineEntry
+}
+
+func newMetricCache(client *AzureClient) *metricCache {
+	return &metricCache{
+		client:    client,
+		entries:   make(map[cacheKey]*cacheEntry),
+		baselines: make(map[cacheKey]*baselineEntry),
+	}
+}
+
+func (mc *metricCache) entryFor(key cacheKey) *cacheEntry {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	entry, ok := mc.entries[key]
+	if !ok {
+		entry = &cacheEntry{}
+		mc.entries[key] = entry
+	}
+	return entry
+}
+
+func (mc *metricCache) baselineEntryFor(key cacheKey) *baselineEntry {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	entry, ok := mc.baselines[key]
+	if !ok {
+		entry = &baselineEntry{}
+		mc.baselines[key] = entry
+	}
+	return entry
+}
+
+// get returns the cached value for metricNames/namespace on target, fetching
+// it from Azure first if the entry is empty or older than the target's
+// query_interval.
+func (mc *metricCache) get(metricNames, namespace string, target config.Target) (AzureMetricValueResponse, error) {
+	entry := mc.entryFor(cacheKeyFor(metricNames, namespace, target))
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if !entry.fetchedAt.IsZero() && time.Since(entry.fetchedAt) < queryIntervalForTarget(target) {
+		cacheHitsTotal.Inc()
+		return entry.value, entry.err
+	}
+
+	apiCallsTotal.Inc()
+	entry.value, entry.err = mc.client.getMetricValue(metricNames, namespace, target)
+	entry.fetchedAt = time.Now().UTC()
+	return entry.value, entry.err
+}
+
+// getBaseline returns the cached computed baseline for metricName/namespace
+// on target, fetching it first if the entry is empty or older than the
+// target's query_interval, the same staleness rule get applies to metric
+// values — so baseline-enabled targets don't re-hit the baselines API on
+// every scrape.
+func (mc *metricCache) getBaseline(metricName, namespace string, target config.Target) (AzureMetricBaselineResponse, error) {
+	entry := mc.baselineEntryFor(cacheKeyFor(metricName, namespace, target))
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if !entry.fetchedAt.IsZero() && time.Since(entry.fetchedAt) < queryIntervalForTarget(target) {
+		cacheHitsTotal.Inc()
+		return entry.value, entry.err
+	}
+
+	apiCallsTotal.Inc()
+	entry.value, entry.err = mc.client.getMetricBaseline(metricName, namespace, target)
+	entry.fetchedAt = time.Now().UTC()
+	return entry.value, entry.err
+}
+
+// start launches a background goroutine per target that proactively
+// refreshes its cache entries on their configured query_interval,
+// independent of when Prometheus actually scrapes.
+func (mc *metricCache) start(stop <-chan struct{}) {
+	for _, target := range sc.C.Targets {
+		go mc.refreshLoop(target, stop)
+	}
+}
+
+func (mc *metricCache) refreshLoop(target config.Target, stop <-chan struct{}) {
+	ticker := time.NewTicker(queryIntervalForTarget(target))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			mc.refreshTarget(target)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// refreshTarget warms the cache entries a real scrape will actually ask for:
+// one per namespace target resolves to, keyed by the metric names in that
+// namespace's definitions.
+func (mc *metricCache) refreshTarget(target config.Target) {
+	namespacedDefs, err := mc.client.getMetricDefinitionsForTarget(target)
+	if err != nil {
+		log.Printf("Error refreshing metric definitions for %s: %v", target.Resource, err)
+		return
+	}
+
+	for _, nd := range namespacedDefs {
+		metricNames := metricNamesFor(nd.def)
+		if metricNames == "" {
+			continue
+		}
+		if _, err := mc.get(metricNames, nd.namespace, target); err != nil {
+			log.Printf("Error refreshing cached metrics for %s: %v", target.Resource, err)
+		}
+
+		if !target.Baselines {
+			continue
+		}
+		for _, def := range nd.def.MetricDefinitionResponses {
+			if _, err := mc.getBaseline(def.Name.Value, nd.namespace, target); err != nil {
+				log.Printf("Error refreshing cached baseline for %s: %v", target.Resource, err)
+			}
+		}
+	}
+}
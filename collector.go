@@ -0,0 +1,168 @@
+package main
+
+import (
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/RobustPerception/azure_metrics_exporter/config"
+)
+
+var metricValueDesc = prometheus.NewDesc(
+	"azure_metric_value",
+	"Value of an Azure Monitor metric.",
+	[]string{"resource", "metric", "unit", "aggregation", "namespace"}, nil,
+)
+
+var (
+	baselineLowDesc = prometheus.NewDesc(
+		"azure_metric_baseline_low",
+		"Lower sensitivity threshold of an Azure Monitor metric's computed baseline.",
+		[]string{"resource", "metric", "namespace", "sensitivity"}, nil,
+	)
+	baselineHighDesc = prometheus.NewDesc(
+		"azure_metric_baseline_high",
+		"Upper sensitivity threshold of an Azure Monitor metric's computed baseline.",
+		[]string{"resource", "metric", "namespace", "sensitivity"}, nil,
+	)
+	baselinePredictedDesc = prometheus.NewDesc(
+		"azure_metric_baseline_predicted",
+		"Azure Monitor's predicted value for a metric, from its computed baseline.",
+		[]string{"resource", "metric", "namespace"}, nil,
+	)
+)
+
+// AzureCollector implements prometheus.Collector, turning the metric values
+// fetched from Azure Monitor for every configured target into Prometheus
+// metrics on each scrape. Metric values and baselines are served out of a
+// metricCache, so how often they are queried from Azure Monitor does not
+// follow the Prometheus scrape interval. Metric definitions (and, for
+// auto-discovered namespaces, the namespace list) are not cached: they are
+// fetched from Azure on every scrape.
+type AzureCollector struct {
+	client *AzureClient
+	cache  *metricCache
+	stop   chan struct{}
+}
+
+// NewAzureCollector wires an AzureClient into a prometheus.Collector, and
+// starts the background refresh loop backing its metric cache.
+func NewAzureCollector(client *AzureClient) *AzureCollector {
+	c := &AzureCollector{
+		client: client,
+		cache:  newMetricCache(client),
+		stop:   make(chan struct{}),
+	}
+	c.cache.start(c.stop)
+	return c
+}
+
+func (c *AzureCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- metricValueDesc
+	ch <- baselineLowDesc
+	ch <- baselineHighDesc
+	ch <- baselinePredictedDesc
+}
+
+func (c *AzureCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, target := range sc.C.Targets {
+		namespacedDefs, err := c.client.getMetricDefinitionsForTarget(target)
+		if err != nil {
+			log.Printf("Error fetching metric definitions for %s: %v", target.Resource, err)
+			continue
+		}
+
+		for _, nd := range namespacedDefs {
+			metricNames := metricNamesFor(nd.def)
+			if metricNames == "" {
+				continue
+			}
+
+			value, err := c.cache.get(metricNames, nd.namespace, target)
+			if err != nil {
+				log.Printf("Error fetching metric values for %s: %v", target.Resource, err)
+				continue
+			}
+
+			c.emitMetricValues(ch, target, nd.namespace, value)
+
+			if target.Baselines {
+				c.emitBaselines(ch, target, nd)
+			}
+		}
+	}
+}
+
+// emitMetricValues converts a metric value response into Prometheus metrics,
+// one per configured aggregation and data point, labeled by namespace so
+// identically-named metrics from different namespaces don't collide.
+func (c *AzureCollector) emitMetricValues(ch chan<- prometheus.Metric, target config.Target, namespace string, resp AzureMetricValueResponse) {
+	aggregations := target.Aggregations
+	if len(aggregations) == 0 {
+		aggregations = []string{"Total", "Average", "Minimum", "Maximum"}
+	}
+
+	for _, v := range resp.Value {
+		for _, ts := range v.Timeseries {
+			for _, data := range ts.Data {
+				for _, aggregation := range aggregations {
+					var value float64
+					switch aggregation {
+					case "Total":
+						value = data.Total
+					case "Average":
+						value = data.Average
+					case "Minimum":
+						value = data.Minimum
+					case "Maximum":
+						value = data.Maximum
+					default:
+						continue
+					}
+					ch <- prometheus.MustNewConstMetric(
+						metricValueDesc, prometheus.GaugeValue, value,
+						target.Resource, v.Name.Value, v.Unit, aggregation, namespace,
+					)
+				}
+			}
+		}
+	}
+}
+
+// emitBaselines fetches and emits the computed sensitivity-band baseline for
+// every metric in nd, one metric at a time since getMetricBaseline's API only
+// accepts a single metric name per call. Fetches go through the same
+// metricCache as metric values, so baseline-enabled targets don't re-hit the
+// baselines API on every scrape.
+func (c *AzureCollector) emitBaselines(ch chan<- prometheus.Metric, target config.Target, nd namespacedDefinition) {
+	for _, def := range nd.def.MetricDefinitionResponses {
+		metricName := def.Name.Value
+		baseline, err := c.cache.getBaseline(metricName, nd.namespace, target)
+		if err != nil {
+			log.Printf("Error fetching metric baseline for %s/%s: %v", target.Resource, metricName, err)
+			continue
+		}
+
+		for _, band := range baseline.Baseline {
+			for _, low := range band.LowThresholds {
+				ch <- prometheus.MustNewConstMetric(
+					baselineLowDesc, prometheus.GaugeValue, low,
+					target.Resource, metricName, nd.namespace, band.Sensitivity,
+				)
+			}
+			for _, high := range band.HighThresholds {
+				ch <- prometheus.MustNewConstMetric(
+					baselineHighDesc, prometheus.GaugeValue, high,
+					target.Resource, metricName, nd.namespace, band.Sensitivity,
+				)
+			}
+		}
+
+		for _, data := range baseline.PredictedResult.Data {
+			ch <- prometheus.MustNewConstMetric(
+				baselinePredictedDesc, prometheus.GaugeValue, data.Average,
+				target.Resource, metricName, nd.namespace,
+			)
+		}
+	}
+}